@@ -0,0 +1,115 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The hint file layout, consumed directly by cmd/libsnap-confine-private so
+// that snap-confine can decide whether a snap is inhibited without shelling
+// out to snapd:
+//
+//	+-------------+---------+----------------+-----------+-----------------+
+//	| magic (4)   | ver (1) | hint len (2 BE) | hint (...) | InhibitInfo (...) |
+//	+-------------+---------+----------------+-----------+-----------------+
+//
+// The InhibitInfo blob is the remainder of the file, JSON-encoded, and may be
+// empty. A zero-length file has no header at all and decodes to
+// HintNotInhibited: this is the state Unlock leaves behind by truncating the
+// file, and it must keep meaning "not inhibited" for snap-confine to stay in
+// sync with "snap run".
+const (
+	hintFileMagic   = "SNPI"
+	hintFileVersion = 1
+)
+
+// encodeHintFile renders hint and info using the binary layout documented
+// above.
+func encodeHintFile(hint Hint, info InhibitInfo) ([]byte, error) {
+	if len(hint) > math.MaxUint16 {
+		return nil, fmt.Errorf("hint too long")
+	}
+	infoBuf, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(hintFileMagic)
+	buf.WriteByte(hintFileVersion)
+	var hintLen [2]byte
+	binary.BigEndian.PutUint16(hintLen[:], uint16(len(hint)))
+	buf.Write(hintLen[:])
+	buf.WriteString(string(hint))
+	buf.Write(infoBuf)
+	return buf.Bytes(), nil
+}
+
+// DecodeHintFile decodes the binary hint file layout written by
+// LockWithHint.
+//
+// A zero-length stream decodes to HintNotInhibited, matching the state
+// Unlock leaves behind. A stream with an unrecognized magic or version is
+// rejected, rather than guessed at, so that a future incompatible layout
+// cannot be silently misread by snap-confine or other out-of-process
+// readers.
+func DecodeHintFile(r io.Reader) (Hint, InhibitInfo, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return "", InhibitInfo{}, err
+	}
+	if len(buf) == 0 {
+		return HintNotInhibited, InhibitInfo{}, nil
+	}
+
+	headerLen := len(hintFileMagic) + 1 + 2
+	if len(buf) < headerLen {
+		return "", InhibitInfo{}, fmt.Errorf("cannot decode hint file: too short")
+	}
+	if string(buf[:len(hintFileMagic)]) != hintFileMagic {
+		return "", InhibitInfo{}, fmt.Errorf("cannot decode hint file: unrecognized magic")
+	}
+	pos := len(hintFileMagic)
+	if buf[pos] != hintFileVersion {
+		return "", InhibitInfo{}, fmt.Errorf("cannot decode hint file: unsupported version %d", buf[pos])
+	}
+	pos++
+	hintLen := int(binary.BigEndian.Uint16(buf[pos : pos+2]))
+	pos += 2
+	if pos+hintLen > len(buf) {
+		return "", InhibitInfo{}, fmt.Errorf("cannot decode hint file: truncated hint")
+	}
+	hint := Hint(buf[pos : pos+hintLen])
+	pos += hintLen
+
+	var info InhibitInfo
+	if pos < len(buf) {
+		if err := json.Unmarshal(buf[pos:], &info); err != nil {
+			return "", InhibitInfo{}, err
+		}
+	}
+	return hint, info, nil
+}
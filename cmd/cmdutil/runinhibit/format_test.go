@@ -0,0 +1,153 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snapcore/snapd/snap"
+)
+
+func TestEncodeDecodeHintFileRoundTrip(t *testing.T) {
+	info := InhibitInfo{
+		Previous: snap.R(3),
+		ChangeID: "7",
+		Time:     time.Now().UTC().Round(0),
+		Snap:     "some-snap",
+	}
+	buf, err := encodeHintFile(HintInhibitedForRefresh, info)
+	if err != nil {
+		t.Fatalf("encodeHintFile failed: %v", err)
+	}
+
+	hint, gotInfo, err := DecodeHintFile(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("DecodeHintFile failed: %v", err)
+	}
+	if hint != HintInhibitedForRefresh {
+		t.Errorf("hint = %q, want %q", hint, HintInhibitedForRefresh)
+	}
+	if !gotInfo.Time.Equal(info.Time) || gotInfo.Previous != info.Previous ||
+		gotInfo.ChangeID != info.ChangeID || gotInfo.Snap != info.Snap {
+		t.Errorf("info = %+v, want %+v", gotInfo, info)
+	}
+}
+
+func TestDecodeHintFileEmptyIsNotInhibited(t *testing.T) {
+	hint, info, err := DecodeHintFile(bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("DecodeHintFile failed: %v", err)
+	}
+	if hint != HintNotInhibited {
+		t.Errorf("hint = %q, want HintNotInhibited", hint)
+	}
+	if info != (InhibitInfo{}) {
+		t.Errorf("info = %+v, want zero value", info)
+	}
+}
+
+func TestDecodeHintFileRejectsUnknownMagic(t *testing.T) {
+	if _, _, err := DecodeHintFile(bytes.NewReader([]byte("XXXX\x01\x00\x00"))); err == nil {
+		t.Error("expected an error for an unrecognized magic")
+	}
+}
+
+func TestDecodeHintFileRejectsUnknownVersion(t *testing.T) {
+	buf := []byte(hintFileMagic) // "SNPI"
+	buf = append(buf, 0xff)      // bogus version
+	buf = append(buf, 0x00, 0x00)
+	if _, _, err := DecodeHintFile(bytes.NewReader(buf)); err == nil {
+		t.Error("expected an error for an unsupported version")
+	}
+}
+
+func TestDecodeHintFileRejectsTruncatedHint(t *testing.T) {
+	buf := []byte(hintFileMagic)
+	buf = append(buf, hintFileVersion)
+	buf = append(buf, 0x00, 0x10) // claims a 16 byte hint, but none follows
+	if _, _, err := DecodeHintFile(bytes.NewReader(buf)); err == nil {
+		t.Error("expected an error for a truncated hint")
+	}
+}
+
+func TestDecodeHintFileRejectsLegacyJSON(t *testing.T) {
+	// DecodeHintFile is the strict, snap-confine-facing contract: only
+	// readHint (below) knows how to fall back to formats written by an
+	// older snapd.
+	legacy := []byte(`{"hint":"refresh","snap-name":"some-snap"}`)
+	hint, info, err := DecodeHintFile(bytes.NewReader(legacy))
+	if err == nil {
+		t.Fatalf("DecodeHintFile unexpectedly accepted legacy JSON as the binary layout: hint=%q info=%+v", hint, info)
+	}
+}
+
+func TestReadHintFallsBackToBareString(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "hint")
+	if err != nil {
+		t.Fatalf("cannot create temp file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("refresh"); err != nil {
+		t.Fatalf("cannot write temp file: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("cannot seek temp file: %v", err)
+	}
+
+	hint, info, err := readHint(f)
+	if err != nil {
+		t.Fatalf("readHint failed: %v", err)
+	}
+	if hint != HintInhibitedForRefresh {
+		t.Errorf("hint = %q, want %q", hint, HintInhibitedForRefresh)
+	}
+	if info != (InhibitInfo{}) {
+		t.Errorf("info = %+v, want zero value", info)
+	}
+}
+
+func TestReadHintFallsBackToLegacyJSONViaFile(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "hint")
+	if err != nil {
+		t.Fatalf("cannot create temp file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(`{"hint":"refresh","snap-name":"some-snap"}`); err != nil {
+		t.Fatalf("cannot write temp file: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("cannot seek temp file: %v", err)
+	}
+
+	hint, info, err := readHint(f)
+	if err != nil {
+		t.Fatalf("readHint failed: %v", err)
+	}
+	if hint != HintInhibitedForRefresh {
+		t.Errorf("hint = %q, want %q", hint, HintInhibitedForRefresh)
+	}
+	if info.Snap != "some-snap" {
+		t.Errorf("info.Snap = %q, want %q", info.Snap, "some-snap")
+	}
+}
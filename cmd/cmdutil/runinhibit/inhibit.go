@@ -22,12 +22,17 @@
 package runinhibit
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
 )
 
 // defaultInhibitDir is the directory where inhibition files are stored.
@@ -51,25 +56,116 @@ const (
 	HintNotInhibited Hint = ""
 	// HintInhibitedForRefresh represents inhibition of a "snap run" while a refresh change is being performed.
 	HintInhibitedForRefresh Hint = "refresh"
+	// HintInhibitedForRemove represents inhibition of a "snap run" while the snap is being removed.
+	HintInhibitedForRemove Hint = "remove"
 )
 
+// HintFile returns the path of the run inhibition lock file for the given
+// snap. Other processes, notably snap-confine, rely on this path to locate
+// the file directly.
+func HintFile(snapName string) string {
+	return filepath.Join(InhibitDir, snapName+".lock")
+}
+
 func openHintFile(snapName string) (*osutil.FileLock, error) {
-	fname := filepath.Join(InhibitDir, snapName+".lock")
-	return osutil.NewFileLockWithMode(fname, 0644)
+	return osutil.NewFileLockWithMode(HintFile(snapName), 0644)
+}
+
+// RemoveLockFile removes the run inhibition lock file for the given snap.
+//
+// It is used when a snap is fully removed, since there is no hint left to
+// keep around at that point.
+func RemoveLockFile(snapName string) error {
+	if err := os.Remove(HintFile(snapName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
-// LockWithHint sets a persistent "snap run" inhibition lock with a given hint.
+// LockMode describes whether WithLocked should take a shared (read) or
+// exclusive (write) flock on the hint file.
+type LockMode int
+
+const (
+	// LockModeRead takes a shared lock, suitable for inspecting the hint.
+	LockModeRead LockMode = iota
+	// LockModeWrite takes an exclusive lock, suitable for mutating the hint.
+	LockModeWrite
+)
+
+// WithLocked opens the hint file for the given snap, takes a lock in the
+// requested mode and calls fn with the locked file. The lock is always
+// released, and the file always closed, once fn returns, regardless of
+// whether fn succeeded.
+//
+// This mirrors the lockedfile pattern used elsewhere in the Go tool chain so
+// that callers cannot forget to release the lock on an error path.
+func WithLocked(snapName string, mode LockMode, fn func(*os.File) error) error {
+	flock, err := openHintFile(snapName)
+	if err != nil {
+		return err
+	}
+	defer flock.Close()
+
+	switch mode {
+	case LockModeRead:
+		err = flock.ReadLock()
+	case LockModeWrite:
+		err = flock.Lock()
+	default:
+		return fmt.Errorf("unknown lock mode %v", mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	return fn(flock.File())
+}
+
+// InhibitInfo holds additional details about why "snap run" is inhibited.
+//
+// It is recorded alongside the hint so that callers woken up from
+// WaitWhileInhibited can make decisions (e.g. re-exec the previous
+// revision) without having to consult snapd again.
+type InhibitInfo struct {
+	// Previous is the revision of the snap that was running before the
+	// change that inhibited "snap run" was started.
+	Previous snap.Revision `json:"previous-revision"`
+	// ChangeID is the ID of the change (typically a refresh) that
+	// inhibited "snap run".
+	ChangeID string `json:"refresh-change-id"`
+	// Time is when the inhibition was put in place.
+	Time time.Time `json:"time"`
+	// Snap is the instance name of the inhibited snap.
+	Snap string `json:"snap-name"`
+}
+
+// hintFileData is the legacy, pre-binary-layout JSON representation of a
+// hint file, kept around so that readHint can still make sense of a file
+// written by an older snapd.
+type hintFileData struct {
+	Hint Hint `json:"hint"`
+	InhibitInfo
+}
+
+// LockWithHint sets a persistent "snap run" inhibition lock with a given hint
+// and accompanying info.
 //
 // The hint cannot be empty. It should be one of the Hint constants defined in
 // this package. While the hint in place "snap run" will not allow the snap to
 // start and will block, presenting a user interface if possible.
-func LockWithHint(snapName string, hint Hint) error {
+func LockWithHint(snapName string, hint Hint, info InhibitInfo) error {
 	if len(hint) == 0 {
 		return fmt.Errorf("hint cannot be empty")
 	}
 	if err := os.MkdirAll(InhibitDir, 0755); err != nil {
 		return err
 	}
+	buf, err := encodeHintFile(hint, info)
+	if err != nil {
+		return err
+	}
+
 	flock, err := openHintFile(snapName)
 	if err != nil {
 		return err
@@ -79,11 +175,21 @@ func LockWithHint(snapName string, hint Hint) error {
 	if err := flock.Lock(); err != nil {
 		return err
 	}
+	// Write in place, under the held lock, rather than via a
+	// temp-file-and-rename: flock(2) locks are bound to the inode
+	// obtained at open() time, not to the path, so renaming a new file
+	// over the locked one would silently orphan the lock. Callers that
+	// hold a long-lived fd across the path (e.g. WaitWhileInhibited's
+	// retry loop, or the fd handed back to a caller that takes
+	// ownership of it) must keep seeing writes made through this path.
 	f := flock.File()
 	if err := f.Truncate(0); err != nil {
 		return err
 	}
-	_, err = f.WriteString(string(hint))
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
 	return err
 }
 
@@ -109,32 +215,53 @@ func Unlock(snapName string) error {
 
 // IsLocked returns information about the run inhibition hint, if any.
 func IsLocked(snapName string) (Hint, error) {
+	hint, _, err := IsLockedWithInfo(snapName)
+	return hint, err
+}
+
+// IsLockedWithInfo returns the run inhibition hint, if any, along with the
+// InhibitInfo recorded alongside it.
+func IsLockedWithInfo(snapName string) (Hint, InhibitInfo, error) {
 	flock, err := openHintFile(snapName)
 	if os.IsNotExist(err) {
-		return "", nil
+		return "", InhibitInfo{}, nil
 	}
 	if err != nil {
-		return "", err
+		return "", InhibitInfo{}, err
 	}
 	defer flock.Close()
 
 	if err := flock.ReadLock(); err != nil {
-		return "", err
+		return "", InhibitInfo{}, err
 	}
 
-	f := flock.File()
-	fi, err := f.Stat()
+	return readHint(flock.File())
+}
+
+// readHint reads the hint and InhibitInfo stored in an already-locked hint
+// file.
+//
+// It understands the current binary layout (see DecodeHintFile) as well as
+// the formats written by older snapd: the JSON-wrapped hintFileData, and,
+// further back, a bare hint string with no structure at all.
+func readHint(f *os.File) (Hint, InhibitInfo, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", InhibitInfo{}, err
+	}
+	buf, err := io.ReadAll(f)
 	if err != nil {
-		return "", err
+		return "", InhibitInfo{}, err
 	}
-	if fi.Size() == 0 {
-		return "", nil
+
+	if hint, info, err := DecodeHintFile(bytes.NewReader(buf)); err == nil {
+		return hint, info, nil
 	}
 
-	buf := make([]byte, fi.Size())
-	n, err := f.Read(buf)
-	if n == len(buf) {
-		return Hint(string(buf)), nil
+	var data hintFileData
+	if err := json.Unmarshal(buf, &data); err == nil {
+		return data.Hint, data.InhibitInfo, nil
 	}
-	return "", err
+
+	// Fall back to the legacy bare-hint representation.
+	return Hint(string(buf)), InhibitInfo{}, nil
 }
@@ -0,0 +1,213 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/snapcore/snapd/cmd/cmdutil/runinhibit"
+	"github.com/snapcore/snapd/snap"
+)
+
+func mockInhibitDir(t *testing.T) string {
+	dir := t.TempDir()
+	old := runinhibit.InhibitDir
+	runinhibit.InhibitDir = dir
+	t.Cleanup(func() { runinhibit.InhibitDir = old })
+	return dir
+}
+
+func TestLockWithHintAndIsLockedWithInfo(t *testing.T) {
+	mockInhibitDir(t)
+
+	info := runinhibit.InhibitInfo{
+		Previous: snap.R(7),
+		ChangeID: "123",
+		Snap:     "some-snap",
+	}
+	if err := runinhibit.LockWithHint("some-snap", runinhibit.HintInhibitedForRefresh, info); err != nil {
+		t.Fatalf("LockWithHint failed: %v", err)
+	}
+
+	hint, gotInfo, err := runinhibit.IsLockedWithInfo("some-snap")
+	if err != nil {
+		t.Fatalf("IsLockedWithInfo failed: %v", err)
+	}
+	if hint != runinhibit.HintInhibitedForRefresh {
+		t.Errorf("hint = %q, want %q", hint, runinhibit.HintInhibitedForRefresh)
+	}
+	if gotInfo != info {
+		t.Errorf("info = %+v, want %+v", gotInfo, info)
+	}
+
+	// IsLocked is a thin wrapper that drops the info.
+	plainHint, err := runinhibit.IsLocked("some-snap")
+	if err != nil {
+		t.Fatalf("IsLocked failed: %v", err)
+	}
+	if plainHint != runinhibit.HintInhibitedForRefresh {
+		t.Errorf("IsLocked hint = %q, want %q", plainHint, runinhibit.HintInhibitedForRefresh)
+	}
+}
+
+func TestIsLockedWithInfoNotInhibited(t *testing.T) {
+	mockInhibitDir(t)
+
+	hint, info, err := runinhibit.IsLockedWithInfo("absent-snap")
+	if err != nil {
+		t.Fatalf("IsLockedWithInfo failed: %v", err)
+	}
+	if hint != runinhibit.HintNotInhibited {
+		t.Errorf("hint = %q, want HintNotInhibited", hint)
+	}
+	if info != (runinhibit.InhibitInfo{}) {
+		t.Errorf("info = %+v, want zero value", info)
+	}
+}
+
+func TestIsLockedWithInfoLegacyBareHint(t *testing.T) {
+	dir := mockInhibitDir(t)
+	path := filepath.Join(dir, "some-snap.lock")
+	if err := os.WriteFile(path, []byte("refresh"), 0644); err != nil {
+		t.Fatalf("cannot write legacy hint file: %v", err)
+	}
+
+	hint, info, err := runinhibit.IsLockedWithInfo("some-snap")
+	if err != nil {
+		t.Fatalf("IsLockedWithInfo failed: %v", err)
+	}
+	if hint != runinhibit.HintInhibitedForRefresh {
+		t.Errorf("hint = %q, want %q", hint, runinhibit.HintInhibitedForRefresh)
+	}
+	if info != (runinhibit.InhibitInfo{}) {
+		t.Errorf("info = %+v, want zero value", info)
+	}
+}
+
+func TestUnlockResetsToNotInhibited(t *testing.T) {
+	mockInhibitDir(t)
+
+	if err := runinhibit.LockWithHint("some-snap", runinhibit.HintInhibitedForRemove, runinhibit.InhibitInfo{}); err != nil {
+		t.Fatalf("LockWithHint failed: %v", err)
+	}
+	if err := runinhibit.Unlock("some-snap"); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	hint, err := runinhibit.IsLocked("some-snap")
+	if err != nil {
+		t.Fatalf("IsLocked failed: %v", err)
+	}
+	if hint != runinhibit.HintNotInhibited {
+		t.Errorf("hint = %q, want HintNotInhibited", hint)
+	}
+}
+
+func TestWaitWhileInhibitedNotInhibited(t *testing.T) {
+	mockInhibitDir(t)
+
+	var notInhibitedCalled, inhibitedCalled bool
+	lock, err := runinhibit.WaitWhileInhibited(context.Background(), "some-snap",
+		func(ctx context.Context) error {
+			notInhibitedCalled = true
+			return nil
+		},
+		func(ctx context.Context, hint runinhibit.Hint, info *runinhibit.InhibitInfo) (bool, error) {
+			inhibitedCalled = true
+			return false, nil
+		},
+		time.Millisecond,
+	)
+	if err != nil {
+		t.Fatalf("WaitWhileInhibited failed: %v", err)
+	}
+	if lock != nil {
+		t.Errorf("expected a nil lock, got %v", lock)
+	}
+	if !notInhibitedCalled || inhibitedCalled {
+		t.Errorf("notInhibitedCalled=%v inhibitedCalled=%v", notInhibitedCalled, inhibitedCalled)
+	}
+}
+
+func TestWaitWhileInhibitedStopsAndKeepsLock(t *testing.T) {
+	mockInhibitDir(t)
+
+	info := runinhibit.InhibitInfo{ChangeID: "42"}
+	if err := runinhibit.LockWithHint("some-snap", runinhibit.HintInhibitedForRefresh, info); err != nil {
+		t.Fatalf("LockWithHint failed: %v", err)
+	}
+
+	lock, err := runinhibit.WaitWhileInhibited(context.Background(), "some-snap",
+		nil,
+		func(ctx context.Context, hint runinhibit.Hint, gotInfo *runinhibit.InhibitInfo) (bool, error) {
+			if hint != runinhibit.HintInhibitedForRefresh {
+				t.Errorf("hint = %q, want %q", hint, runinhibit.HintInhibitedForRefresh)
+			}
+			if gotInfo == nil || *gotInfo != info {
+				t.Errorf("info = %+v, want %+v", gotInfo, info)
+			}
+			return false, nil
+		},
+		time.Millisecond,
+	)
+	if err != nil {
+		t.Fatalf("WaitWhileInhibited failed: %v", err)
+	}
+	if lock == nil {
+		t.Fatal("expected a held lock to be returned")
+	}
+	lock.Close()
+}
+
+func TestWaitWhileInhibitedPropagatesCallbackError(t *testing.T) {
+	mockInhibitDir(t)
+
+	boom := errors.New("boom")
+	if err := runinhibit.LockWithHint("some-snap", runinhibit.HintInhibitedForRefresh, runinhibit.InhibitInfo{}); err != nil {
+		t.Fatalf("LockWithHint failed: %v", err)
+	}
+
+	_, err := runinhibit.WaitWhileInhibited(context.Background(), "some-snap",
+		nil,
+		func(ctx context.Context, hint runinhibit.Hint, info *runinhibit.InhibitInfo) (bool, error) {
+			return false, boom
+		},
+		time.Millisecond,
+	)
+	if !errors.Is(err, boom) {
+		t.Errorf("err = %v, want %v", err, boom)
+	}
+}
+
+func TestWaitWhileInhibitedRejectsNonPositiveInterval(t *testing.T) {
+	mockInhibitDir(t)
+
+	if _, err := runinhibit.WaitWhileInhibited(context.Background(), "some-snap", nil, nil, 0); err == nil {
+		t.Error("expected an error for a zero interval")
+	}
+	if _, err := runinhibit.WaitWhileInhibited(context.Background(), "some-snap", nil, nil, -time.Second); err == nil {
+		t.Error("expected an error for a negative interval")
+	}
+}
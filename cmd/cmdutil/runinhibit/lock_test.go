@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/snapcore/snapd/cmd/cmdutil/runinhibit"
+)
+
+func TestHintFile(t *testing.T) {
+	dir := mockInhibitDir(t)
+	want := filepath.Join(dir, "some-snap.lock")
+	if got := runinhibit.HintFile("some-snap"); got != want {
+		t.Errorf("HintFile = %q, want %q", got, want)
+	}
+}
+
+func TestRemoveLockFile(t *testing.T) {
+	mockInhibitDir(t)
+
+	if err := runinhibit.LockWithHint("some-snap", runinhibit.HintInhibitedForRemove, runinhibit.InhibitInfo{}); err != nil {
+		t.Fatalf("LockWithHint failed: %v", err)
+	}
+	if err := runinhibit.RemoveLockFile("some-snap"); err != nil {
+		t.Fatalf("RemoveLockFile failed: %v", err)
+	}
+	if _, err := os.Stat(runinhibit.HintFile("some-snap")); !os.IsNotExist(err) {
+		t.Errorf("expected lock file to be gone, stat err = %v", err)
+	}
+
+	// Removing an already-absent lock file is not an error.
+	if err := runinhibit.RemoveLockFile("some-snap"); err != nil {
+		t.Errorf("RemoveLockFile on absent file failed: %v", err)
+	}
+}
+
+func TestWithLockedRead(t *testing.T) {
+	mockInhibitDir(t)
+
+	if err := runinhibit.LockWithHint("some-snap", runinhibit.HintInhibitedForRefresh, runinhibit.InhibitInfo{}); err != nil {
+		t.Fatalf("LockWithHint failed: %v", err)
+	}
+
+	var sawContent bool
+	err := runinhibit.WithLocked("some-snap", runinhibit.LockModeRead, func(f *os.File) error {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		sawContent = fi.Size() > 0
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithLocked failed: %v", err)
+	}
+	if !sawContent {
+		t.Error("expected WithLocked to hand over the locked, non-empty file")
+	}
+}
+
+func TestWithLockedWrite(t *testing.T) {
+	mockInhibitDir(t)
+
+	err := runinhibit.WithLocked("some-snap", runinhibit.LockModeWrite, func(f *os.File) error {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := f.WriteString(string(runinhibit.HintInhibitedForRefresh))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithLocked failed: %v", err)
+	}
+
+	hint, err := runinhibit.IsLocked("some-snap")
+	if err != nil {
+		t.Fatalf("IsLocked failed: %v", err)
+	}
+	if hint != runinhibit.HintInhibitedForRefresh {
+		t.Errorf("hint = %q, want %q written inside WithLocked's fn", hint, runinhibit.HintInhibitedForRefresh)
+	}
+}
+
+func TestWithLockedUnknownMode(t *testing.T) {
+	mockInhibitDir(t)
+
+	err := runinhibit.WithLocked("some-snap", runinhibit.LockMode(99), func(f *os.File) error {
+		t.Fatal("fn must not run for an unknown lock mode")
+		return nil
+	})
+	if err == nil {
+		t.Error("expected an error for an unknown lock mode")
+	}
+}
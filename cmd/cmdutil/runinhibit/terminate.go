@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit
+
+import (
+	"fmt"
+	"time"
+)
+
+// TerminationError wraps an error returned by the killer function passed to
+// LockAndTerminate. It is kept distinct from other errors LockAndTerminate
+// can return so that callers can tell "failed to record the inhibition" and
+// "inhibition recorded but the snap resisted termination" apart.
+type TerminationError struct {
+	Snap string
+	Err  error
+}
+
+func (e *TerminationError) Error() string {
+	return fmt.Sprintf("cannot terminate running processes of snap %q: %v", e.Snap, e.Err)
+}
+
+func (e *TerminationError) Unwrap() error {
+	return e.Err
+}
+
+// LockAndTerminate puts "snap run" inhibition in place with the given hint
+// and then makes a best-effort attempt at terminating any processes of the
+// snap that are already running, via killer.
+//
+// The hint is written and persisted first, so that "snap run" stops
+// spawning new processes of the snap regardless of whether killer succeeds.
+// A hostile snap that resists termination does not prevent the inhibition
+// from being recorded: killer's error is reported back wrapped in a
+// *TerminationError rather than failing the call outright.
+func LockAndTerminate(snapName string, hint Hint, killer func(snapName string) error) error {
+	if len(hint) == 0 {
+		return fmt.Errorf("hint cannot be empty")
+	}
+
+	info := InhibitInfo{Snap: snapName, Time: time.Now()}
+	if err := LockWithHint(snapName, hint, info); err != nil {
+		return err
+	}
+
+	if killer == nil {
+		return nil
+	}
+	if err := killer(snapName); err != nil {
+		return &TerminationError{Snap: snapName, Err: err}
+	}
+	return nil
+}
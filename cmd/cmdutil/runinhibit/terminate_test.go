@@ -0,0 +1,89 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/snapcore/snapd/cmd/cmdutil/runinhibit"
+)
+
+func TestLockAndTerminateSuccess(t *testing.T) {
+	mockInhibitDir(t)
+
+	var killedName string
+	err := runinhibit.LockAndTerminate("some-snap", runinhibit.HintInhibitedForRemove, func(snapName string) error {
+		killedName = snapName
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("LockAndTerminate failed: %v", err)
+	}
+	if killedName != "some-snap" {
+		t.Errorf("killer called with %q, want %q", killedName, "some-snap")
+	}
+
+	hint, err := runinhibit.IsLocked("some-snap")
+	if err != nil {
+		t.Fatalf("IsLocked failed: %v", err)
+	}
+	if hint != runinhibit.HintInhibitedForRemove {
+		t.Errorf("hint = %q, want %q", hint, runinhibit.HintInhibitedForRemove)
+	}
+}
+
+func TestLockAndTerminateKillerErrorKeepsInhibition(t *testing.T) {
+	mockInhibitDir(t)
+
+	boom := errors.New("boom")
+	err := runinhibit.LockAndTerminate("some-snap", runinhibit.HintInhibitedForRemove, func(snapName string) error {
+		return boom
+	})
+
+	var termErr *runinhibit.TerminationError
+	if !errors.As(err, &termErr) {
+		t.Fatalf("err = %v, want a *TerminationError", err)
+	}
+	if !errors.Is(termErr, boom) {
+		t.Errorf("TerminationError does not wrap %v: %v", boom, termErr)
+	}
+
+	// The inhibition must still be recorded even though the killer failed.
+	hint, err := runinhibit.IsLocked("some-snap")
+	if err != nil {
+		t.Fatalf("IsLocked failed: %v", err)
+	}
+	if hint != runinhibit.HintInhibitedForRemove {
+		t.Errorf("hint = %q, want %q", hint, runinhibit.HintInhibitedForRemove)
+	}
+}
+
+func TestLockAndTerminateEmptyHint(t *testing.T) {
+	mockInhibitDir(t)
+
+	err := runinhibit.LockAndTerminate("some-snap", "", func(snapName string) error {
+		t.Fatal("killer must not run when the hint is rejected")
+		return nil
+	})
+	if err == nil {
+		t.Error("expected an error for an empty hint")
+	}
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// waitWhileInhibitedTicker returns a ticker used to pace retries inside
+// WaitWhileInhibited. It is a variable so that tests can inject a fake
+// ticker instead of waiting on a real clock.
+var waitWhileInhibitedTicker = time.NewTicker
+
+// WaitWhileInhibited blocks while "snap run" is inhibited for the given
+// snap.
+//
+// If the hint file does not exist or carries no hint, notInhibited is
+// invoked and WaitWhileInhibited returns a nil lock. Otherwise the read
+// lock is taken and inhibited is invoked with the current hint. If
+// inhibited returns cont equal to false, the still-held read lock is
+// returned to the caller so that it can atomically proceed with "snap
+// run" without racing a concurrent LockWithHint or Unlock. If cont is
+// true the lock is released, WaitWhileInhibited sleeps for interval (or
+// until ctx is done) and retries.
+//
+// interval must be positive.
+func WaitWhileInhibited(
+	ctx context.Context,
+	snapName string,
+	notInhibited func(ctx context.Context) error,
+	inhibited func(ctx context.Context, hint Hint, info *InhibitInfo) (cont bool, err error),
+	interval time.Duration,
+) (*osutil.FileLock, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+
+	flock, err := openHintFile(snapName)
+	if os.IsNotExist(err) {
+		if notInhibited != nil {
+			if err := notInhibited(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := flock.ReadLock(); err != nil {
+			flock.Close()
+			return nil, err
+		}
+
+		hint, info, err := readHint(flock.File())
+		if err != nil {
+			flock.Close()
+			return nil, err
+		}
+
+		if hint == HintNotInhibited {
+			flock.Close()
+			if notInhibited != nil {
+				return nil, notInhibited(ctx)
+			}
+			return nil, nil
+		}
+
+		cont, err := inhibited(ctx, hint, &info)
+		if err != nil {
+			flock.Close()
+			return nil, err
+		}
+		if !cont {
+			// Caller takes ownership of flock, still read-locked.
+			return flock, nil
+		}
+
+		if err := flock.Unlock(); err != nil {
+			flock.Close()
+			return nil, err
+		}
+
+		ticker := waitWhileInhibitedTicker(interval)
+		select {
+		case <-ticker.C:
+			ticker.Stop()
+		case <-ctx.Done():
+			ticker.Stop()
+			flock.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
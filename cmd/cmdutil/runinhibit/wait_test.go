@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/snapcore/snapd/cmd/cmdutil/runinhibit"
+)
+
+// fakeTicker returns a *time.Ticker whose C channel is the given one,
+// letting a test control exactly when WaitWhileInhibited's retry sleep
+// fires without waiting on a real clock.
+func fakeTicker(ch chan time.Time) func(time.Duration) *time.Ticker {
+	return func(time.Duration) *time.Ticker {
+		return &time.Ticker{C: ch}
+	}
+}
+
+func TestWaitWhileInhibitedRetriesThenStops(t *testing.T) {
+	mockInhibitDir(t)
+
+	if err := runinhibit.LockWithHint("some-snap", runinhibit.HintInhibitedForRefresh, runinhibit.InhibitInfo{}); err != nil {
+		t.Fatalf("LockWithHint failed: %v", err)
+	}
+
+	// Buffered so WaitWhileInhibited's select sees a pending tick right
+	// away, without this test needing to race a goroutine against it.
+	ticks := make(chan time.Time, 1)
+	ticks <- time.Now()
+	restore := runinhibit.MockWaitWhileInhibitedTicker(fakeTicker(ticks))
+	defer restore()
+
+	var calls int
+	lock, err := runinhibit.WaitWhileInhibited(context.Background(), "some-snap",
+		nil,
+		func(ctx context.Context, hint runinhibit.Hint, info *runinhibit.InhibitInfo) (bool, error) {
+			calls++
+			if calls == 1 {
+				// First pass: still inhibited, ask to retry. The retry
+				// must release the lock and come back around.
+				return true, nil
+			}
+			return false, nil
+		},
+		time.Hour, // never actually waited on: the fake ticker fires immediately
+	)
+	if err != nil {
+		t.Fatalf("WaitWhileInhibited failed: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("inhibited called %d times, want 2 (one retry)", calls)
+	}
+	if lock == nil {
+		t.Fatal("expected the second pass to return the held lock")
+	}
+	lock.Close()
+}
+
+func TestWaitWhileInhibitedCancelDuringRetry(t *testing.T) {
+	mockInhibitDir(t)
+
+	if err := runinhibit.LockWithHint("some-snap", runinhibit.HintInhibitedForRefresh, runinhibit.InhibitInfo{}); err != nil {
+		t.Fatalf("LockWithHint failed: %v", err)
+	}
+
+	// Unbuffered and never fed: the only way out of the retry select is
+	// via ctx.Done().
+	ticks := make(chan time.Time)
+	restore := runinhibit.MockWaitWhileInhibitedTicker(fakeTicker(ticks))
+	defer restore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lock, err := runinhibit.WaitWhileInhibited(ctx, "some-snap",
+		nil,
+		func(ctx context.Context, hint runinhibit.Hint, info *runinhibit.InhibitInfo) (bool, error) {
+			// Cancel before the retry sleep is entered, so the pending
+			// select deterministically picks ctx.Done() over the
+			// never-fed ticker channel.
+			cancel()
+			return true, nil
+		},
+		time.Hour,
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if lock != nil {
+		t.Errorf("expected a nil lock after cancellation, got %v", lock)
+		lock.Close()
+	}
+}